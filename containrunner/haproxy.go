@@ -10,7 +10,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -23,6 +25,75 @@ type HAProxySettings struct {
 	HAProxyReloadCommand string
 	HAProxySocket        string
 	FirstConvergeDone    bool
+
+	// Capabilities detected from the running HAProxy on first contact with
+	// the stats socket. Once Detected is true we no longer re-probe on
+	// every convergence.
+	Capabilities HAProxyCapabilities
+
+	// MinReloadInterval enforces a minimum gap between two HAProxy reloads
+	// performed by ConvergeHAProxy, whether called directly or through
+	// QueueConvergeHAProxy. Zero means no throttling.
+	MinReloadInterval time.Duration
+
+	// HAProxyPidFile is the pidfile of the currently running master process,
+	// used by ReloadHAProxy to hand it off via "-sf" instead of shelling out
+	// to HAProxyReloadCommand.
+	HAProxyPidFile string
+
+	// ReloadHealthTimeout bounds how long ReloadHAProxy waits for the new
+	// master to bind and report healthy before rolling back. Zero means
+	// defaultReloadHealthTimeout.
+	ReloadHealthTimeout time.Duration
+
+	// MaxBackups caps how many timestamped config backups CommitNewConfig
+	// keeps around. Zero (the default) means backups are never pruned.
+	MaxBackups int
+
+	// Metrics tracks reload/coalescing activity for QueueConvergeHAProxy.
+	Metrics HAProxyMetrics
+
+	convergeMutex sync.Mutex
+	metricsMutex  sync.Mutex
+	updateOnce    sync.Once
+	updateChan    chan *haproxyUpdateRequest
+
+	// lastModel is the typed model parsed from the last rendered config,
+	// used to compute a ConfigDiff against the newly rendered one. See
+	// haproxy_model.go.
+	lastModel *HAProxyModel
+
+	// convergeFunc is called by the update loop for each request that
+	// survives coalescing. Defaults to ConvergeHAProxy; overridden in tests
+	// so the queueing/coalescing/throttling machinery can be exercised
+	// without touching a real HAProxy.
+	convergeFunc func(*RuntimeConfiguration, *LocalInstanceInformation) error
+}
+
+// HAProxyMetrics exposes counters operators can use to tune
+// MinReloadInterval: how often events are coalesced away versus how often
+// HAProxy actually gets reloaded.
+type HAProxyMetrics struct {
+	CoalescedEvents int64
+	Reloads         int64
+	LastReloadTime  time.Time
+}
+
+// HAProxyCapabilities records which runtime-socket features the currently
+// running HAProxy supports, so UpdateBackends knows whether it can
+// synchronize backend membership without a reload.
+type HAProxyCapabilities struct {
+	Detected             bool
+	SupportsAddDelServer bool
+}
+
+// HAProxyServerSlot is one row of "show servers state" output: a
+// pre-provisioned server slot and the address it currently points at.
+type HAProxyServerSlot struct {
+	BackendName string
+	ServerName  string
+	Address     string
+	Port        string
 }
 
 // Dynamic HAProxy settings receivered from configbridge
@@ -67,6 +138,18 @@ type HAProxyConfigChangeLog struct {
 	OldConfigBackupFile string
 }
 
+// HAProxyReloadFailure is logged whenever a reload fails after the new
+// master was started, e.g. a config that passed "-c" but fails to bind at
+// runtime. After this is logged the previous config is restored from backup
+// in place of AttemptedConfig.
+type HAProxyReloadFailure struct {
+	AttemptedConfig string
+	Stderr          string
+	Cause           string
+}
+
+const defaultReloadHealthTimeout = 10 * time.Second
+
 func NewHAProxyConfiguration() *HAProxyConfiguration {
 	configuration := new(HAProxyConfiguration)
 	configuration.Files = make(map[string]string)
@@ -83,6 +166,12 @@ func NewHAProxyEndpoint() *HAProxyEndpoint {
 }
 
 func (hac *HAProxySettings) ConvergeHAProxy(configuration *RuntimeConfiguration, localInstanceInformation *LocalInstanceInformation) (error) {
+	// Only one "-c" verification or reload may be in flight at a time, so a
+	// convergence triggered while another is still committing/reloading
+	// waits here rather than racing it.
+	hac.convergeMutex.Lock()
+	defer hac.convergeMutex.Unlock()
+
 	log.Debug("ConvergeHAProxy execution started")
 	if configuration.MachineConfiguration.HAProxyConfiguration == nil {
 		log.Warning("Warning, HAProxy config is still nil!")
@@ -101,19 +190,39 @@ func (hac *HAProxySettings) ConvergeHAProxy(configuration *RuntimeConfiguration,
 		return err
 	}
 
+	// UpdateBackends owns backend-membership churn over the runtime socket;
+	// applyModelDiff only ever forces reload_required to true for changes it
+	// can't (frontends, globals, defaults, certs), so the two never push
+	// conflicting runtime commands for the same change.
+	model_reload_required := hac.applyModelDiff(config, configuration.MachineConfiguration.HAProxyConfiguration.Certs)
+	if model_reload_required {
+		reload_required = true
+	}
+
 	if !reload_required && hac.FirstConvergeDone {
 		log.Debug("HAProxy could be updated without changing configuration")
 		return nil
 	}
 
-	err, reload_required = hac.CommitNewConfig(config, true) // true means to do backups
+	var backupConfigFile string
+	err, reload_required, backupConfigFile = hac.CommitNewConfig(config, true) // true means to do backups
 	if err != nil {
 		return err
 	}
 
+	// A certs-only change leaves the config text as it was, so CommitNewConfig
+	// alone would not ask for the reload the model diff requires.
+	if model_reload_required {
+		reload_required = true
+	}
+
 	if reload_required {
-		err = hac.ReloadHAProxy()
+		hac.waitForMinReloadInterval()
+		err = hac.ReloadHAProxy(backupConfigFile, config)
 		hac.FirstConvergeDone = true
+		if err == nil {
+			hac.recordReload()
+		}
 	} else {
 		log.Debug("ConvergeHAProxy called but reload was not required")
 	}
@@ -121,28 +230,270 @@ func (hac *HAProxySettings) ConvergeHAProxy(configuration *RuntimeConfiguration,
 	return err
 }
 
-func (hac *HAProxySettings) ReloadHAProxy() error {
-	if hac.HAProxyReloadCommand != "" {
-		log.Info("Reloading haproxy with " + hac.HAProxyReloadCommand)
-		parts := strings.Fields(hac.HAProxyReloadCommand)
-		head := parts[0]
-		parts = parts[1:len(parts)]
+// QueueConvergeHAProxy is the coalescing entry point for convergence: it
+// starts the single-writer update loop on first use and hands it the latest
+// configuration snapshot. The loop owns all HAProxy mutation, so any
+// snapshot still sitting unconsumed in the channel when a newer one arrives
+// is dropped rather than applied, collapsing bursts of upstream churn into
+// a single convergence.
+func (hac *HAProxySettings) QueueConvergeHAProxy(configuration *RuntimeConfiguration, localInstanceInformation *LocalInstanceInformation) {
+	hac.startUpdateLoop()
+
+	req := &haproxyUpdateRequest{configuration, localInstanceInformation}
+
+	select {
+	case hac.updateChan <- req:
+	default:
+		select {
+		case <-hac.updateChan:
+			hac.metricsMutex.Lock()
+			hac.Metrics.CoalescedEvents++
+			hac.metricsMutex.Unlock()
+		default:
+		}
+		hac.updateChan <- req
+	}
+}
+
+// haproxyUpdateRequest is one snapshot queued through QueueConvergeHAProxy.
+type haproxyUpdateRequest struct {
+	configuration            *RuntimeConfiguration
+	localInstanceInformation *LocalInstanceInformation
+}
+
+func (hac *HAProxySettings) startUpdateLoop() {
+	hac.updateOnce.Do(func() {
+		hac.updateChan = make(chan *haproxyUpdateRequest, 1)
+		go hac.runUpdateLoop()
+	})
+}
+
+func (hac *HAProxySettings) runUpdateLoop() {
+	converge := hac.convergeFunc
+	if converge == nil {
+		converge = hac.ConvergeHAProxy
+	}
 
-		cmd := exec.Command(head, parts...)
-		err := cmd.Start()
+	for req := range hac.updateChan {
+		err := converge(req.configuration, req.localInstanceInformation)
 		if err != nil {
-			panic(err)
+			log.Error(LogString(fmt.Sprintf("Error converging HAProxy from update loop: %+v", err)))
 		}
+	}
+}
 
-		err = cmd.Wait()
-		return err
+// waitForMinReloadInterval blocks until at least MinReloadInterval has
+// passed since the last reload, so a burst of queued convergences cannot
+// reload HAProxy more often than the configured rate. It is only called
+// from inside convergeMutex, so sleeping here naturally throttles the next
+// queued snapshot too.
+func (hac *HAProxySettings) waitForMinReloadInterval() {
+	if hac.MinReloadInterval <= 0 {
+		return
+	}
 
-	} else {
+	hac.metricsMutex.Lock()
+	last := hac.Metrics.LastReloadTime
+	hac.metricsMutex.Unlock()
+
+	if last.IsZero() {
+		return
+	}
+
+	if elapsed := time.Since(last); elapsed < hac.MinReloadInterval {
+		time.Sleep(hac.MinReloadInterval - elapsed)
+	}
+}
+
+func (hac *HAProxySettings) recordReload() {
+	hac.metricsMutex.Lock()
+	defer hac.metricsMutex.Unlock()
+
+	hac.Metrics.Reloads++
+	hac.Metrics.LastReloadTime = time.Now()
+}
+
+// TimeSinceLastReload reports how long it has been since HAProxy was last
+// reloaded, or zero if it has never been reloaded yet.
+func (hac *HAProxySettings) TimeSinceLastReload() time.Duration {
+	hac.metricsMutex.Lock()
+	defer hac.metricsMutex.Unlock()
+
+	if hac.Metrics.LastReloadTime.IsZero() {
+		return 0
+	}
+	return time.Since(hac.Metrics.LastReloadTime)
+}
+
+// ReloadHAProxy reloads HAProxy, then applies the same rollback/pruning
+// sequence regardless of which mechanism actually performed the reload:
+// when HAProxyPidFile is set the reload is handed off seamlessly via
+// reloadWithSeamlessHandoff; otherwise (or if the pidfile can't be read) it
+// falls back to the historical HAProxyReloadCommand behaviour in
+// reloadWithCommand. Either way we wait for the new master to report
+// healthy on the stats socket before declaring success; if it fails to
+// start or never comes up healthy within ReloadHealthTimeout, the config
+// file is rolled back to backupConfigFile and a structured
+// HAProxyReloadFailure event is logged. If neither a pidfile nor a reload
+// command is configured, reload is a documented no-op and none of this
+// runs.
+func (hac *HAProxySettings) ReloadHAProxy(backupConfigFile string, attemptedConfig string) error {
+	if hac.HAProxyPidFile == "" && hac.HAProxyReloadCommand == "" {
 		log.Debug("Tried to reload haproxy but no reload command set!")
+		return nil
+	}
+
+	stderr, err := hac.runReloadCommand()
+	if err != nil {
+		return hac.rollbackReload(backupConfigFile, attemptedConfig, stderr, err)
+	}
+
+	if err := hac.waitForHealthyMaster(); err != nil {
+		return hac.rollbackReload(backupConfigFile, attemptedConfig, stderr, err)
 	}
+
+	hac.pruneBackups()
+
 	return nil
 }
 
+// runReloadCommand actually restarts/reloads the HAProxy process and
+// returns any stderr it produced alongside its error, so callers can
+// surface both through rollbackReload. It prefers the seamless "-sf"/"-x"
+// handoff when HAProxyPidFile is readable, falling back to the configured
+// HAProxyReloadCommand otherwise.
+func (hac *HAProxySettings) runReloadCommand() (string, error) {
+	if hac.HAProxyPidFile != "" {
+		pid, err := hac.readHAProxyPid()
+		if err == nil {
+			return hac.reloadWithSeamlessHandoff(pid)
+		}
+		log.Warning("Could not read HAProxy pidfile " + hac.HAProxyPidFile + ", falling back to configured reload command: " + err.Error())
+	}
+
+	return hac.reloadWithCommand()
+}
+
+// reloadWithSeamlessHandoff starts the new master directly with "-sf <old
+// pid>" and "-x <stats socket>" so it inherits the listening sockets from
+// the outgoing master instead of re-binding them.
+func (hac *HAProxySettings) reloadWithSeamlessHandoff(pid int) (string, error) {
+	args := []string{"-f", hac.HAProxyConfigPath + "/" + hac.HAProxyConfigName, "-p", hac.HAProxyPidFile, "-D", "-sf", strconv.Itoa(pid)}
+
+	sockets, err := filepath.Glob(hac.HAProxySocket)
+	if err == nil && len(sockets) > 0 {
+		args = append(args, "-x", sockets[0])
+	}
+
+	log.Info("Reloading haproxy with " + hac.HAProxyBinary + " " + strings.Join(args, " "))
+
+	cmd := exec.Command(hac.HAProxyBinary, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	return stderr.String(), cmd.Run()
+}
+
+func (hac *HAProxySettings) reloadWithCommand() (string, error) {
+	if hac.HAProxyReloadCommand == "" {
+		return "", nil
+	}
+
+	log.Info("Reloading haproxy with " + hac.HAProxyReloadCommand)
+	parts := strings.Fields(hac.HAProxyReloadCommand)
+	head := parts[0]
+	parts = parts[1:len(parts)]
+
+	cmd := exec.Command(head, parts...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	return stderr.String(), cmd.Run()
+}
+
+func (hac *HAProxySettings) readHAProxyPid() (int, error) {
+	contents, err := ioutil.ReadFile(hac.HAProxyPidFile)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(contents)))
+}
+
+// waitForHealthyMaster polls "show info" on the stats socket until it
+// responds or ReloadHealthTimeout elapses.
+func (hac *HAProxySettings) waitForHealthyMaster() error {
+	timeout := hac.ReloadHealthTimeout
+	if timeout <= 0 {
+		timeout = defaultReloadHealthTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		sockets, err := filepath.Glob(hac.HAProxySocket)
+		if err == nil && len(sockets) > 0 {
+			c, dialErr := net.Dial("unix", sockets[0])
+			if dialErr == nil {
+				c.Write([]byte("show info\n"))
+				info, _ := ioutil.ReadAll(c)
+				c.Close()
+
+				if strings.Contains(string(info), "Pid: ") {
+					return nil
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for new HAProxy master to report healthy")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// rollbackReload restores the config file HAProxy was running before this
+// reload attempt and logs a structured HAProxyReloadFailure event so a
+// bad-but-syntactically-valid config doesn't leave the system broken.
+func (hac *HAProxySettings) rollbackReload(backupConfigFile string, attemptedConfig string, stderr string, cause error) error {
+	log.Error(LogEvent(HAProxyReloadFailure{attemptedConfig, stderr, cause.Error()}))
+
+	if backupConfigFile != "" {
+		err := os.Rename(backupConfigFile, hac.HAProxyConfigPath+"/"+hac.HAProxyConfigName)
+		if err != nil {
+			log.Error(LogString("Could not restore HAProxy config backup after failed reload: " + err.Error()))
+		}
+	}
+
+	return fmt.Errorf("HAProxy reload failed, rolled back to previous config: %+v", cause)
+}
+
+// pruneBackups removes the oldest timestamped config backups beyond
+// MaxBackups, so they don't accumulate one-per-change forever.
+func (hac *HAProxySettings) pruneBackups() {
+	if hac.MaxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(hac.HAProxyConfigPath + "/" + hac.HAProxyConfigName + "-*")
+	if err != nil {
+		return
+	}
+
+	sort.Strings(backups)
+
+	if len(backups) <= hac.MaxBackups {
+		return
+	}
+
+	for _, stale := range backups[:len(backups)-hac.MaxBackups] {
+		if err := os.Remove(stale); err != nil {
+			log.Error(LogString("Could not prune old HAProxy config backup " + stale + ": " + err.Error()))
+		}
+	}
+}
+
 func (hac *HAProxySettings) BuildAndVerifyNewConfig(configuration *RuntimeConfiguration, localInstanceInformation *LocalInstanceInformation) (string, error) {
 
 	new_config, err := ioutil.TempFile(os.TempDir(), "haproxy_new_config_")
@@ -214,7 +565,13 @@ func (hac *HAProxySettings) BuildAndVerifyNewConfig(configuration *RuntimeConfig
 	return config, nil
 }
 
-func (hac *HAProxySettings) CommitNewConfig(config string, backup bool) (error, bool) {
+// CommitNewConfig writes config to disk as the active HAProxy config file.
+// When backup is true, the previous contents are preserved under a
+// timestamped name first, independent of the active file, so that
+// ReloadHAProxy can restore it verbatim if the new config fails to come up
+// healthy. It returns the path of that backup file (empty if none was
+// made) so the caller can pass it to ReloadHAProxy.
+func (hac *HAProxySettings) CommitNewConfig(config string, backup bool) (error, bool, string) {
 
 	l := HAProxyConfigChangeLog{}
 	var contents []byte
@@ -227,21 +584,18 @@ func (hac *HAProxySettings) CommitNewConfig(config string, backup bool) (error,
 
 	if config == string(contents) {
 		log.Debug("CommitNewConfig determined that HAProxy configuration file has not changed")
-		return nil, false
-	} 
-
-	log.Info("HAProxy configuration contents has changed, so writing a new file to %s", hac.HAProxyConfigPath + "/" + hac.HAProxyConfigName)
+		return nil, false, ""
+	}
 
+	log.Info("HAProxy configuration contents has changed, so writing a new file to %s", hac.HAProxyConfigPath+"/"+hac.HAProxyConfigName)
 
-	if backup {
+	if backup && len(contents) > 0 {
 		l.OldConfigBackupFile = hac.HAProxyConfigPath + "/" + hac.HAProxyConfigName + "-" + time.Now().Format(time.RFC3339)
 
-		err = os.Link(hac.HAProxyConfigPath+"/"+hac.HAProxyConfigName, l.OldConfigBackupFile)
-		if err != nil && !os.IsNotExist(err) {
-			log.Error(LogString("Error linking config backup!" + err.Error()))
-			return err, false
-		} else if err != nil && os.IsNotExist(err) {
-			l.OldConfigBackupFile = ""
+		err = ioutil.WriteFile(l.OldConfigBackupFile, contents, 0664)
+		if err != nil {
+			log.Error(LogString("Error writing config backup!" + err.Error()))
+			return err, false, ""
 		}
 	}
 
@@ -250,13 +604,13 @@ func (hac *HAProxySettings) CommitNewConfig(config string, backup bool) (error,
 	err = ioutil.WriteFile(hac.HAProxyConfigPath+"/"+hac.HAProxyConfigName, []byte(config), 0664)
 	if err != nil {
 		log.Error(LogString("Could not write new haproxy config!" + err.Error()))
-		return err, false
+		return err, false, ""
 	}
 
 	mtime := time.Now().Local()
 	os.Chtimes(hac.HAProxyConfigPath+"/haproxy-lastupdated.txt", mtime, mtime)
 
-	return nil, true
+	return nil, true, l.OldConfigBackupFile
 
 }
 
@@ -319,8 +673,9 @@ func (hac *HAProxySettings) GetNewConfig(configuration *RuntimeConfiguration, lo
 	}
 
 	output := new(bytes.Buffer)
-	// Run the template to verify the output.
-	err = tmpl.Execute(output, "the go programming language")
+	// Run the template to verify the output. localInstanceInformation is the
+	// data available to the template as ".".
+	err = tmpl.Execute(output, localInstanceInformation)
 	if err != nil {
 		log.Error("execution: %s", err)
 		return "", err
@@ -407,55 +762,60 @@ func (hac *HAProxySettings) GetHaproxyBackends() (current_backends map[string]ma
 	return current_backends, err
 }
 
-func (hac *HAProxySettings) UpdateBackends(configuration *RuntimeConfiguration, localInstanceInformation *LocalInstanceInformation) (bool, error) {
-
-	current_backends, err := hac.GetHaproxyBackends()
-	if err != nil {
-		return true, nil
-	}
-
-	commands := ""
-
+// planBackendSync is the pure decision logic behind UpdateBackends: given the
+// backend sections haproxy currently knows about (from GetHaproxyBackends),
+// their per-server state (from GetHaproxyServersState) and the endpoints
+// each service should have, it returns the runtime API commands needed to
+// converge haproxy's running state in place, or reload_required=true when
+// runtime commands cannot do the job (missing section, unsupported haproxy
+// version, or nothing left enabled). A freshly "add server"-ed endpoint is
+// enabled in the same batch, since "add server" alone leaves the slot in
+// MAINT and the reconciliation loop below only walks current_backends, which
+// was snapshotted before the add.
+func planBackendSync(current_backends map[string]map[string]string, server_slots map[string]map[string]HAProxyServerSlot, desired_backends map[string][]string, supportsAddDelServer bool) (commands string, reload_required bool) {
 	enabled_backends := make(map[string]bool)
-	total_backends := 0
-
-	//fmt.Printf("current backends: %+v\n", current_backends)
 
-	fmt.Printf("LocallyRequiredServices: %+v\n", localInstanceInformation.LocallyRequiredServices)
-
-	for service_name, backend_servers := range localInstanceInformation.LocallyRequiredServices {
-		fmt.Printf("Service backend for service_name %s: %+v", service_name, backend_servers)
+	for service_name, backend_servers := range desired_backends {
 		// Check that there actually is configured servers for this backend before dooming that haproxy needs to be restarted
 		if _, ok := current_backends[service_name]; ok == false && len(backend_servers) > 0 {
-			fmt.Printf("Restart required: missing section %s. Notice that the backend name must match the individual endpoint names.\n", service_name)
-			//fmt.Printf("current backends: %+v\n", current_backends)
-			//fmt.Printf("locally required services: %+v\n", configuration.LocallyRequiredServices)
-			return true, nil
+			return "", true
 		}
-		for backendServer := range backend_servers {
-			if _, ok := current_backends[service_name][service_name+"-"+backendServer]; ok == false {
-				fmt.Printf("Restart required: missing endpoint %s from section %s\n", service_name+"-"+backendServer, service_name)
-				return true, nil
+
+		for _, backendServer := range backend_servers {
+			backend := service_name + "-" + backendServer
+			if _, ok := current_backends[service_name][backend]; ok == false {
+				if !supportsAddDelServer {
+					return "", true
+				}
+
+				commands += "add server " + service_name + "/" + backend + " " + backendServer + "\n"
+				commands += "enable server " + service_name + "/" + backend + "\n"
+			} else if slot, ok := server_slots[service_name][backend]; ok && slot.Address+":"+slot.Port != backendServer {
+				host, port, splitErr := net.SplitHostPort(backendServer)
+				if splitErr == nil {
+					commands += "set server " + service_name + "/" + backend + " addr " + host + " port " + port + "\n"
+				}
 			}
-			enabled_backends[service_name+"-"+backendServer] = true
+			enabled_backends[backend] = true
 		}
 	}
-	//fmt.Printf("enabled backends: %+v\n", enabled_backends)
+
 	if len(enabled_backends) == 0 {
-		fmt.Printf("No enabled backends, will not disable anything\n")
-		return true, nil
+		return "", true
 	}
 
 	for section_name, section_backends := range current_backends {
 		for backend, backend_status := range section_backends {
-			total_backends++
 			command := ""
 			if _, ok := enabled_backends[backend]; ok == true {
 				if backend_status == "MAINT" {
 					command = "enable server " + section_name + "/" + backend + "\n"
 				}
 			} else if strings.Index(backend, "nocheck-") == -1 { // having "nocheck-" prefix on backend server name prevents orbit from disabling the backend
-				if backend_status != "MAINT" {
+				if supportsAddDelServer {
+					command = "disable server " + section_name + "/" + backend + "\n"
+					command += "del server " + section_name + "/" + backend + "\n"
+				} else if backend_status != "MAINT" {
 					command = "disable server " + section_name + "/" + backend + "\n"
 				}
 			}
@@ -470,6 +830,45 @@ func (hac *HAProxySettings) UpdateBackends(configuration *RuntimeConfiguration,
 		}
 	}
 
+	return commands, false
+}
+
+// UpdateBackends synchronizes the running HAProxy's backend membership with
+// localInstanceInformation.LocallyRequiredServices over the stats/runtime
+// socket. When the running HAProxy is new enough (2.4+, see
+// detectCapabilities) it grows and shrinks server slots in place with
+// "add server"/"del server" so that backend churn never needs a reload;
+// older HAProxy versions (or a missing backend/frontend section, which
+// "add server" cannot create) fall back to reporting reload_required=true
+// so the caller re-renders the template and reloads.
+func (hac *HAProxySettings) UpdateBackends(configuration *RuntimeConfiguration, localInstanceInformation *LocalInstanceInformation) (bool, error) {
+
+	current_backends, err := hac.GetHaproxyBackends()
+	if err != nil {
+		return true, nil
+	}
+
+	hac.detectCapabilities()
+
+	server_slots, err := hac.GetHaproxyServersState()
+	if err != nil {
+		server_slots = nil
+	}
+
+	desired_backends := make(map[string][]string)
+	for service_name, backend_servers := range localInstanceInformation.LocallyRequiredServices {
+		hostports := make([]string, 0, len(backend_servers))
+		for backendServer := range backend_servers {
+			hostports = append(hostports, backendServer)
+		}
+		desired_backends[service_name] = hostports
+	}
+
+	commands, reload_required := planBackendSync(current_backends, server_slots, desired_backends, hac.Capabilities.SupportsAddDelServer)
+	if reload_required {
+		return true, nil
+	}
+
 	if len(commands) > 0 {
 
 		sockets, err := filepath.Glob(hac.HAProxySocket)
@@ -489,8 +888,138 @@ func (hac *HAProxySettings) UpdateBackends(configuration *RuntimeConfiguration,
 		err = ioutil.WriteFile(hac.HAProxyConfigPath+"/haproxy-lastupdated.txt", []byte(commands), 0664)
 		if err != nil {
 			log.Error("Could not update haproxy-lastupdated file due to error: %+v", err)
-		}		
+		}
 	}
 
 	return false, nil
 }
+
+// detectCapabilities probes "show info" on the stats socket once and caches
+// whether the running HAProxy is new enough to support the runtime
+// "add server"/"del server" commands (added in 2.4). Safe to call on every
+// convergence; it is a no-op once Capabilities.Detected is true.
+func (hac *HAProxySettings) detectCapabilities() {
+	if hac.Capabilities.Detected {
+		return
+	}
+
+	sockets, err := filepath.Glob(hac.HAProxySocket)
+	if err != nil || len(sockets) == 0 {
+		return
+	}
+
+	c, err := net.Dial("unix", sockets[0])
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	_, err = c.Write([]byte("show info\n"))
+	if err != nil {
+		return
+	}
+
+	info, err := ioutil.ReadAll(c)
+	if err != nil {
+		return
+	}
+
+	hac.Capabilities.SupportsAddDelServer = haproxyVersionSupportsAddDelServer(string(info))
+	hac.Capabilities.Detected = true
+}
+
+// haproxyVersionSupportsAddDelServer parses the "Version: x.y.z" line out of
+// "show info" output and reports whether that version is 2.4 or newer, the
+// first release to understand runtime "add server"/"del server".
+func haproxyVersionSupportsAddDelServer(info string) bool {
+	for _, line := range strings.Split(info, "\n") {
+		if !strings.HasPrefix(line, "Version: ") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(line, "Version: "), ".", 3)
+		if len(parts) < 2 {
+			return false
+		}
+
+		major, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return false
+		}
+		minor, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return false
+		}
+
+		return major > 2 || (major == 2 && minor >= 4)
+	}
+
+	return false
+}
+
+// GetHaproxyServersState reads "show servers state" from the stats socket so
+// UpdateBackends knows the address each pre-provisioned server slot is
+// currently pointed at, allowing in-place "set server ... addr" changes
+// instead of a disable/add/del dance when only an endpoint's address moved.
+func (hac *HAProxySettings) GetHaproxyServersState() (map[string]map[string]HAProxyServerSlot, error) {
+	sockets, err := filepath.Glob(hac.HAProxySocket)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sockets) == 0 {
+		return nil, nil
+	}
+
+	c, err := net.Dial("unix", sockets[0])
+	if err != nil {
+		return nil, nil
+	}
+	defer c.Close()
+
+	_, err = c.Write([]byte("show servers state\n"))
+	if err != nil {
+		return nil, nil
+	}
+
+	output, err := ioutil.ReadAll(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseShowServersState(string(output)), nil
+}
+
+// parseShowServersState parses the body of "show servers state": a version
+// line, a "# be_id be_name srv_id srv_name srv_addr ..." header, and then
+// one space-separated row per server slot. See HAProxy's management.txt for
+// the full column layout; we only need backend name, server name, address
+// and port.
+func parseShowServersState(output string) map[string]map[string]HAProxyServerSlot {
+	servers := make(map[string]map[string]HAProxyServerSlot)
+
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 19 {
+			continue
+		}
+
+		slot := HAProxyServerSlot{
+			BackendName: fields[1],
+			ServerName:  fields[3],
+			Address:     fields[4],
+			Port:        fields[18],
+		}
+
+		if _, ok := servers[slot.BackendName]; !ok {
+			servers[slot.BackendName] = make(map[string]HAProxyServerSlot)
+		}
+		servers[slot.BackendName][slot.ServerName] = slot
+	}
+
+	return servers
+}
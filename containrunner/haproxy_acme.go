@@ -0,0 +1,416 @@
+package containrunner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// HAProxyACMESettings configures automatic certificate issuance and renewal
+// for the domains HAProxy terminates TLS for. It replaces the historical
+// model of configbridge pushing a static HAProxyConfiguration.Certs map:
+// instead this process obtains and renews the certs itself and hands them,
+// in the same file name -> PEM shape, to HAProxyACME.Converge. It does not
+// write them into HAProxyConfiguration.Certs itself.
+type HAProxyACMESettings struct {
+	Domains []string
+
+	// DirectoryURL is the ACME server directory. Defaults to the Let's
+	// Encrypt production directory when empty.
+	DirectoryURL string
+
+	// RenewBefore is how much validity must remain on a cert before it is
+	// renewed. Defaults to 30 days.
+	RenewBefore time.Duration
+
+	// RenewInterval is how often the renewal timer wakes up to check
+	// expiry. Defaults to 12 hours.
+	RenewInterval time.Duration
+
+	// HTTPChallengeAddr is the address the HTTP-01 challenge responder
+	// listens on, e.g. ":80". Left empty, domains are validated via
+	// TLS-ALPN-01 instead, hot-added to the running HAProxy over the
+	// runtime socket.
+	HTTPChallengeAddr string
+}
+
+// ACMEStore persists the ACME account key and issued certificates in etcd,
+// alongside the existing configbridge data, so every orbit node converges
+// on the same certificate material rather than each node racing to issue
+// its own.
+type ACMEStore interface {
+	GetAccountKey() ([]byte, error)
+	PutAccountKey(key []byte) error
+	GetCert(domain string) (fullchainPEM []byte, keyPEM []byte, err error)
+	PutCert(domain string, fullchainPEM []byte, keyPEM []byte) error
+}
+
+const (
+	defaultACMEDirectoryURL  = "https://acme-v02.api.letsencrypt.org/directory"
+	defaultACMERenewBefore   = 30 * 24 * time.Hour
+	defaultACMERenewInterval = 12 * time.Hour
+)
+
+// HAProxyACME drives ACME issuance and renewal for a HAProxySettings
+// instance. On every renewal tick it makes sure every configured domain
+// has a cert that isn't close to expiry, persists newly issued material in
+// Store and calls Converge with the certs of all configured domains so the
+// caller can push a normal convergence through with the updated certs.
+type HAProxyACME struct {
+	Settings HAProxyACMESettings
+	Store    ACMEStore
+	HAProxy  *HAProxySettings
+
+	// Converge is called whenever a certificate is issued or renewed, with
+	// a Certs-style map ("<domain>.pem" -> fullchain followed by key) of
+	// every configured domain that has cert material. Static certs from
+	// HAProxyConfiguration.Certs are not in that map. Nothing in this
+	// package sets Converge; while it is nil, issued certs are only
+	// persisted in Store.
+	Converge func(certs map[string]string)
+
+	client     *acme.Client
+	clientOnce sync.Once
+	clientErr  error
+}
+
+// NewHAProxyACME builds a HAProxyACME from settings, a Store and the
+// HAProxySettings whose runtime socket is used to serve TLS-ALPN-01
+// challenges. Domains without a valid unexpired cert in Store are issued
+// on the first RenewCertificates call rather than here, so construction
+// never blocks on the network.
+func NewHAProxyACME(settings HAProxyACMESettings, store ACMEStore, hac *HAProxySettings) *HAProxyACME {
+	if settings.DirectoryURL == "" {
+		settings.DirectoryURL = defaultACMEDirectoryURL
+	}
+	if settings.RenewBefore <= 0 {
+		settings.RenewBefore = defaultACMERenewBefore
+	}
+	if settings.RenewInterval <= 0 {
+		settings.RenewInterval = defaultACMERenewInterval
+	}
+
+	return &HAProxyACME{Settings: settings, Store: store, HAProxy: hac}
+}
+
+// acmeClient lazily builds the ACME client, loading the account key from
+// Store or creating and persisting a new one on first use.
+func (a *HAProxyACME) acmeClient() (*acme.Client, error) {
+	a.clientOnce.Do(func() {
+		key, err := a.loadOrCreateAccountKey()
+		if err != nil {
+			a.clientErr = err
+			return
+		}
+
+		client := &acme.Client{Key: key, DirectoryURL: a.Settings.DirectoryURL}
+		if _, err := client.Register(context.Background(), &acme.Account{}, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+			a.clientErr = err
+			return
+		}
+
+		a.client = client
+	})
+
+	return a.client, a.clientErr
+}
+
+func (a *HAProxyACME) loadOrCreateAccountKey() (*ecdsa.PrivateKey, error) {
+	if der, err := a.Store.GetAccountKey(); err == nil && len(der) > 0 {
+		return x509.ParseECPrivateKey(der)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.Store.PutAccountKey(der); err != nil {
+		log.Error(LogString("Could not persist ACME account key to etcd: " + err.Error()))
+	}
+
+	return key, nil
+}
+
+// RenewCertificates checks every configured domain for a cert that is
+// missing or within RenewBefore of expiry, issues/renews it, persists it in
+// Store and, if anything changed, calls Converge with a Certs-style map of
+// every domain that has cert material so HAProxy picks up the new material
+// on the next convergence.
+func (a *HAProxyACME) RenewCertificates() error {
+	certs := make(map[string]string)
+	changed := false
+
+	for _, domain := range a.Settings.Domains {
+		fullchain, key, err := a.Store.GetCert(domain)
+		if err != nil || a.needsRenewal(fullchain) {
+			log.Info("Obtaining ACME certificate for " + domain)
+
+			fullchain, key, err = a.obtainCert(domain)
+			if err != nil {
+				log.Error(LogString(fmt.Sprintf("ACME issuance failed for %s: %+v", domain, err)))
+				continue
+			}
+
+			if err := a.Store.PutCert(domain, fullchain, key); err != nil {
+				log.Error(LogString("Could not persist ACME cert for " + domain + " to etcd: " + err.Error()))
+			}
+
+			changed = true
+		}
+
+		if len(fullchain) == 0 || len(key) == 0 {
+			log.Warning("Skipping " + domain + " in this convergence: no valid ACME cert material available")
+			continue
+		}
+
+		certs[domain+".pem"] = string(fullchain) + string(key)
+	}
+
+	if changed && a.Converge != nil {
+		a.Converge(certs)
+	}
+
+	return nil
+}
+
+// needsRenewal reports whether fullchain is absent, unparseable or within
+// RenewBefore of expiry.
+func (a *HAProxyACME) needsRenewal(fullchainPEM []byte) bool {
+	if len(fullchainPEM) == 0 {
+		return true
+	}
+
+	block, _ := pem.Decode(fullchainPEM)
+	if block == nil {
+		return true
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+
+	return time.Until(cert.NotAfter) < a.Settings.RenewBefore
+}
+
+// obtainCert runs a single ACME order to completion for domain, validating
+// it via HTTP-01 when HTTPChallengeAddr is set and falling back to
+// TLS-ALPN-01 otherwise, and returns the issued fullchain and private key
+// as PEM.
+func (a *HAProxyACME) obtainCert(domain string) (fullchainPEM []byte, keyPEM []byte, err error) {
+	client, err := a.acmeClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authz, err := client.Authorize(context.Background(), domain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup, err := a.completeChallenge(client, authz, domain)
+	if cleanup != nil {
+		defer cleanup()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := client.WaitAuthorization(context.Background(), authz.URI); err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr, err := buildCertificateRequest(domain, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, _, err := client.CreateCert(context.Background(), csr, 0, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var chain []byte
+	for _, certDER := range der {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})...)
+	}
+
+	return chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// pickChallenge selects which of authz's offered challenges to complete:
+// HTTP-01 when a responder address is configured and offered, otherwise
+// TLS-ALPN-01. Returns nil if neither is offered.
+func pickChallenge(challenges []*acme.Challenge, haveHTTPResponder bool) *acme.Challenge {
+	var picked *acme.Challenge
+	for _, c := range challenges {
+		if haveHTTPResponder && c.Type == "http-01" {
+			return c
+		}
+		if c.Type == "tls-alpn-01" {
+			picked = c
+		}
+	}
+	return picked
+}
+
+// completeChallenge picks an HTTP-01 challenge when a responder address is
+// configured, otherwise a TLS-ALPN-01 challenge served by hot-adding the
+// challenge cert to the running HAProxy over the runtime socket, and tells
+// the ACME server it's ready to be validated. For HTTP-01 it returns a
+// cleanup func the caller must run once validation has actually completed
+// (client.WaitAuthorization returns) -- the ACME server fetches the
+// challenge URL well after Accept returns, so the responder can't be torn
+// down here.
+func (a *HAProxyACME) completeChallenge(client *acme.Client, authz *acme.Authorization, domain string) (cleanup func(), err error) {
+	challenge := pickChallenge(authz.Challenges, a.Settings.HTTPChallengeAddr != "")
+	if challenge == nil {
+		return nil, errors.New("no supported ACME challenge offered for " + domain)
+	}
+
+	switch challenge.Type {
+	case "http-01":
+		cleanup, err = a.serveHTTPChallenge(client, challenge)
+		if err != nil {
+			return nil, err
+		}
+	case "tls-alpn-01":
+		if err := a.serveTLSALPNChallenge(client, challenge, domain); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := client.Accept(context.Background(), challenge); err != nil {
+		return cleanup, err
+	}
+
+	return cleanup, nil
+}
+
+// serveHTTPChallenge answers the HTTP-01 challenge by starting a temporary
+// responder on HTTPChallengeAddr. It does not add a frontend or backend to
+// the HAProxy config: the ACME server reaches the responder only if
+// HTTPChallengeAddr is itself reachable on port 80 or the configured
+// template already routes "/.well-known/acme-challenge/" to it. The
+// returned func stops the responder; the
+// caller must not call it until the ACME server has finished validating the
+// challenge, since that's when the responder actually gets hit.
+func (a *HAProxyACME) serveHTTPChallenge(client *acme.Client, challenge *acme.Challenge) (func(), error) {
+	keyAuth, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/acme-challenge/"+challenge.Token, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, keyAuth)
+	})
+
+	server := &http.Server{Addr: a.Settings.HTTPChallengeAddr, Handler: mux}
+
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- server.ListenAndServe() }()
+
+	select {
+	case err := <-listenErr:
+		return nil, err
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	return func() { server.Close() }, nil
+}
+
+// serveTLSALPNChallenge answers the TLS-ALPN-01 challenge by hot-adding the
+// challenge certificate to the running HAProxy over the runtime socket,
+// using the "set ssl cert"/"commit ssl cert" runtime commands on the same
+// socket UpdateBackends uses for server churn, so validation never needs a
+// reload.
+func (a *HAProxyACME) serveTLSALPNChallenge(client *acme.Client, challenge *acme.Challenge, domain string) error {
+	cert, err := client.TLSALPN01ChallengeCert(challenge.Token, domain)
+	if err != nil {
+		return err
+	}
+
+	var pemBundle []byte
+	for _, der := range cert.Certificate {
+		pemBundle = append(pemBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	pemBundle = append(pemBundle, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...)
+
+	sockets, err := filepath.Glob(a.HAProxy.HAProxySocket)
+	if err != nil || len(sockets) == 0 {
+		return errors.New("no HAProxy socket available to serve tls-alpn-01 challenge")
+	}
+
+	fname := "acme-tls-alpn-challenge.pem"
+	commands := fmt.Sprintf("set ssl cert %s <<\n%scommit ssl cert %s\n", fname, pemBundle, fname)
+
+	for _, socket_name := range sockets {
+		if err := runHAProxyCommand(commands, socket_name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildCertificateRequest builds a minimal CSR for domain signed by key,
+// suitable for client.CreateCert.
+func buildCertificateRequest(domain string, key *ecdsa.PrivateKey) ([]byte, error) {
+	template := x509.CertificateRequest{DNSNames: []string{domain}}
+	return x509.CreateCertificateRequest(rand.Reader, &template, key)
+}
+
+// StartRenewalTimer runs RenewCertificates once and then on every
+// RenewInterval tick until stop is closed.
+func (a *HAProxyACME) StartRenewalTimer(stop <-chan struct{}) {
+	if err := a.RenewCertificates(); err != nil {
+		log.Error(LogString("Initial ACME renewal pass failed: " + err.Error()))
+	}
+
+	ticker := time.NewTicker(a.Settings.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.RenewCertificates(); err != nil {
+				log.Error(LogString("ACME renewal pass failed: " + err.Error()))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
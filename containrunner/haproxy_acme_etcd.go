@@ -0,0 +1,90 @@
+package containrunner
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// etcdKV is the subset of clientv3.Client's API EtcdACMEStore needs.
+// *clientv3.Client satisfies it without any explicit wiring since its Get
+// and Put methods (promoted from the embedded clientv3.KV) already match;
+// tests supply a fake instead of standing up a real etcd cluster.
+type etcdKV interface {
+	Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error)
+	Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error)
+}
+
+// EtcdACMEStore is the production ACMEStore: it keeps the ACME account key
+// and issued certs under Prefix in the same etcd cluster configbridge uses,
+// so every orbit node renewing the same domain converges on one set of
+// certificates instead of each node obtaining its own.
+type EtcdACMEStore struct {
+	Client etcdKV
+	Prefix string
+}
+
+const (
+	acmeAccountKeyPath   = "account_key"
+	acmeCertFullchainKey = "fullchain.pem"
+	acmeCertKeyKey       = "key.pem"
+)
+
+func (s *EtcdACMEStore) key(parts ...string) string {
+	return strings.TrimRight(s.Prefix, "/") + "/" + strings.Join(parts, "/")
+}
+
+// GetAccountKey returns the DER-encoded ACME account private key previously
+// stored with PutAccountKey, or an error if none has been stored yet.
+func (s *EtcdACMEStore) GetAccountKey() ([]byte, error) {
+	resp, err := s.Client.Get(context.Background(), s.key(acmeAccountKeyPath))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, errors.New("no ACME account key stored in etcd")
+	}
+
+	return resp.Kvs[0].Value, nil
+}
+
+// PutAccountKey persists the DER-encoded ACME account private key.
+func (s *EtcdACMEStore) PutAccountKey(key []byte) error {
+	_, err := s.Client.Put(context.Background(), s.key(acmeAccountKeyPath), string(key))
+	return err
+}
+
+// GetCert returns the PEM fullchain and private key last stored for domain
+// via PutCert, or an error if domain has never had a cert issued. It fetches
+// the two keys exactly rather than a prefix scan over "domains/<domain>" so
+// that one domain's name being a literal prefix of another's (e.g.
+// "example.com" and "example.com.au") can't pull in the wrong cert material.
+func (s *EtcdACMEStore) GetCert(domain string) (fullchainPEM []byte, keyPEM []byte, err error) {
+	fullchainResp, err := s.Client.Get(context.Background(), s.key("domains", domain, acmeCertFullchainKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyResp, err := s.Client.Get(context.Background(), s.key("domains", domain, acmeCertKeyKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(fullchainResp.Kvs) == 0 || len(keyResp.Kvs) == 0 {
+		return nil, nil, errors.New("no ACME cert stored in etcd for " + domain)
+	}
+
+	return fullchainResp.Kvs[0].Value, keyResp.Kvs[0].Value, nil
+}
+
+// PutCert persists the PEM fullchain and private key issued for domain.
+func (s *EtcdACMEStore) PutCert(domain string, fullchainPEM []byte, keyPEM []byte) error {
+	if _, err := s.Client.Put(context.Background(), s.key("domains", domain, acmeCertFullchainKey), string(fullchainPEM)); err != nil {
+		return err
+	}
+
+	_, err := s.Client.Put(context.Background(), s.key("domains", domain, acmeCertKeyKey), string(keyPEM))
+	return err
+}
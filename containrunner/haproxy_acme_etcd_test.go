@@ -0,0 +1,103 @@
+package containrunner
+
+import (
+	"context"
+	"testing"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// fakeEtcdKV is a minimal in-memory stand-in for etcdKV so EtcdACMEStore can
+// be tested without a real etcd cluster.
+type fakeEtcdKV struct {
+	values map[string]string
+}
+
+func newFakeEtcdKV() *fakeEtcdKV {
+	return &fakeEtcdKV{values: make(map[string]string)}
+}
+
+func (f *fakeEtcdKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	v, ok := f.values[key]
+	if !ok {
+		return &clientv3.GetResponse{}, nil
+	}
+	return &clientv3.GetResponse{Kvs: []*clientv3.KeyValue{{Key: []byte(key), Value: []byte(v)}}}, nil
+}
+
+func (f *fakeEtcdKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	f.values[key] = val
+	return &clientv3.PutResponse{}, nil
+}
+
+func TestEtcdACMEStoreAccountKeyRoundTrip(t *testing.T) {
+	store := &EtcdACMEStore{Client: newFakeEtcdKV(), Prefix: "/acme"}
+
+	if _, err := store.GetAccountKey(); err == nil {
+		t.Fatalf("expected an error before any account key has been stored")
+	}
+
+	if err := store.PutAccountKey([]byte("der-bytes")); err != nil {
+		t.Fatalf("PutAccountKey: %+v", err)
+	}
+
+	got, err := store.GetAccountKey()
+	if err != nil {
+		t.Fatalf("GetAccountKey: %+v", err)
+	}
+	if string(got) != "der-bytes" {
+		t.Errorf("expected %q, got %q", "der-bytes", got)
+	}
+}
+
+func TestEtcdACMEStoreCertRoundTrip(t *testing.T) {
+	store := &EtcdACMEStore{Client: newFakeEtcdKV(), Prefix: "/acme"}
+
+	if err := store.PutCert("example.com", []byte("fullchain-a"), []byte("key-a")); err != nil {
+		t.Fatalf("PutCert: %+v", err)
+	}
+
+	fullchain, key, err := store.GetCert("example.com")
+	if err != nil {
+		t.Fatalf("GetCert: %+v", err)
+	}
+	if string(fullchain) != "fullchain-a" || string(key) != "key-a" {
+		t.Errorf("expected (fullchain-a, key-a), got (%q, %q)", fullchain, key)
+	}
+
+	if _, _, err := store.GetCert("no-such-domain.example.com"); err == nil {
+		t.Fatalf("expected an error for a domain with no stored cert")
+	}
+}
+
+// TestEtcdACMEStoreCertDomainPrefixIsolation is the regression test for the
+// cross-domain bleed bug: "example.com" is a literal prefix of
+// "example.com.au", so a naive prefix scan over "domains/example.com" would
+// also match "domains/example.com.au/..." and silently mix their cert
+// material together.
+func TestEtcdACMEStoreCertDomainPrefixIsolation(t *testing.T) {
+	store := &EtcdACMEStore{Client: newFakeEtcdKV(), Prefix: "/acme"}
+
+	if err := store.PutCert("example.com", []byte("short-fullchain"), []byte("short-key")); err != nil {
+		t.Fatalf("PutCert(example.com): %+v", err)
+	}
+	if err := store.PutCert("example.com.au", []byte("long-fullchain"), []byte("long-key")); err != nil {
+		t.Fatalf("PutCert(example.com.au): %+v", err)
+	}
+
+	fullchain, key, err := store.GetCert("example.com")
+	if err != nil {
+		t.Fatalf("GetCert(example.com): %+v", err)
+	}
+	if string(fullchain) != "short-fullchain" || string(key) != "short-key" {
+		t.Errorf("expected example.com's own cert material, got (%q, %q)", fullchain, key)
+	}
+
+	fullchain, key, err = store.GetCert("example.com.au")
+	if err != nil {
+		t.Fatalf("GetCert(example.com.au): %+v", err)
+	}
+	if string(fullchain) != "long-fullchain" || string(key) != "long-key" {
+		t.Errorf("expected example.com.au's own cert material, got (%q, %q)", fullchain, key)
+	}
+}
@@ -0,0 +1,102 @@
+package containrunner
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func selfSignedCertPEM(t *testing.T, notAfter time.Time) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %+v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %+v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestNeedsRenewalEmptyOrUnparseable(t *testing.T) {
+	a := &HAProxyACME{Settings: HAProxyACMESettings{RenewBefore: 30 * 24 * time.Hour}}
+
+	if !a.needsRenewal(nil) {
+		t.Errorf("expected needsRenewal(nil) to be true")
+	}
+	if !a.needsRenewal([]byte("not a pem")) {
+		t.Errorf("expected needsRenewal(garbage) to be true")
+	}
+}
+
+func TestNeedsRenewalExpiryBoundary(t *testing.T) {
+	a := &HAProxyACME{Settings: HAProxyACMESettings{RenewBefore: 30 * 24 * time.Hour}}
+
+	fresh := selfSignedCertPEM(t, time.Now().Add(90*24*time.Hour))
+	if a.needsRenewal(fresh) {
+		t.Errorf("expected a cert 90 days from expiry to not need renewal yet")
+	}
+
+	expiringSoon := selfSignedCertPEM(t, time.Now().Add(10*24*time.Hour))
+	if !a.needsRenewal(expiringSoon) {
+		t.Errorf("expected a cert 10 days from expiry to need renewal")
+	}
+
+	alreadyExpired := selfSignedCertPEM(t, time.Now().Add(-24*time.Hour))
+	if !a.needsRenewal(alreadyExpired) {
+		t.Errorf("expected an already-expired cert to need renewal")
+	}
+}
+
+func TestPickChallengePrefersHTTP01WhenResponderConfigured(t *testing.T) {
+	challenges := []*acme.Challenge{
+		{Type: "tls-alpn-01"},
+		{Type: "http-01"},
+		{Type: "dns-01"},
+	}
+
+	got := pickChallenge(challenges, true)
+	if got == nil || got.Type != "http-01" {
+		t.Fatalf("expected http-01 to be picked, got %+v", got)
+	}
+}
+
+func TestPickChallengeFallsBackToTLSALPNWithoutHTTPResponder(t *testing.T) {
+	challenges := []*acme.Challenge{
+		{Type: "http-01"},
+		{Type: "tls-alpn-01"},
+		{Type: "dns-01"},
+	}
+
+	got := pickChallenge(challenges, false)
+	if got == nil || got.Type != "tls-alpn-01" {
+		t.Fatalf("expected tls-alpn-01 to be picked when no HTTP responder is configured, got %+v", got)
+	}
+}
+
+func TestPickChallengeNoneSupported(t *testing.T) {
+	challenges := []*acme.Challenge{{Type: "dns-01"}}
+
+	if got := pickChallenge(challenges, true); got != nil {
+		t.Fatalf("expected no challenge to be picked, got %+v", got)
+	}
+}
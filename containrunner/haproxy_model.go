@@ -0,0 +1,450 @@
+package containrunner
+
+import (
+	"strconv"
+	"strings"
+)
+
+// HAProxyModel is a typed intermediate representation of a rendered
+// HAProxy configuration. GetNewConfig still produces the config as text by
+// executing the configured template, but that text is parsed into a
+// HAProxyModel so ConvergeHAProxy can Diff it against the previously
+// applied one instead of only ever knowing "the bytes changed". Declarative
+// config authoring can populate a HAProxyModel directly and Render it,
+// bypassing the template entirely.
+type HAProxyModel struct {
+	Globals  []string
+	Defaults []string
+
+	Frontends []Frontend
+	Backends  []Backend
+
+	// Certs mirrors HAProxyConfiguration.Certs (file name -> PEM contents)
+	// as of the convergence that produced this model. It isn't part of the
+	// rendered config text at all -- certs.d/ is populated separately by
+	// BuildAndVerifyNewConfig -- but a cert rotation still has to force a
+	// reload for the running HAProxy to pick up the new material, so Diff
+	// treats it as one more thing that can change between two models.
+	Certs map[string]string
+}
+
+// Frontend is one "frontend <name>" section.
+type Frontend struct {
+	Name        string
+	Binds       []string
+	ACLs        []ACL
+	UseBackends []UseBackend
+
+	// Options holds every other directive verbatim, in file order.
+	Options []string
+}
+
+// Backend is one "backend <name>" section.
+type Backend struct {
+	Name    string
+	Servers []BackendServer
+
+	// Options holds every directive other than "server" verbatim, in file
+	// order (e.g. "balance roundrobin", health check defaults).
+	Options []string
+}
+
+// BackendServer is one "server" line of a Backend.
+type BackendServer struct {
+	Name    string
+	Address string
+	Weight  int
+	MaxConn int
+
+	// Options holds any other tokens on the server line verbatim (e.g.
+	// "check", "ssl").
+	Options []string
+}
+
+// ACL is one "acl <name> <condition...>" line of a Frontend.
+type ACL struct {
+	Name      string
+	Condition string
+}
+
+// UseBackend is one "use_backend <name> [if <condition>]" line of a
+// Frontend. Condition is empty for an unconditional (default) use_backend.
+type UseBackend struct {
+	Backend   string
+	Condition string
+}
+
+// ParseHAProxyModel parses rendered HAProxy config text into a HAProxyModel.
+// It understands the subset of the config language orbit itself emits
+// (global/defaults/frontend/backend sections, bind/acl/use_backend/server
+// directives) and keeps anything else as opaque Options lines so it still
+// round-trips through Render without data loss.
+func ParseHAProxyModel(configText string) (*HAProxyModel, error) {
+	model := &HAProxyModel{}
+
+	var currentFrontend *Frontend
+	var currentBackend *Backend
+	section := ""
+
+	flush := func() {
+		if currentFrontend != nil {
+			model.Frontends = append(model.Frontends, *currentFrontend)
+			currentFrontend = nil
+		}
+		if currentBackend != nil {
+			model.Backends = append(model.Backends, *currentBackend)
+			currentBackend = nil
+		}
+	}
+
+	for _, raw := range strings.Split(configText, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		keyword := fields[0]
+
+		switch keyword {
+		case "global":
+			flush()
+			section = "global"
+			continue
+		case "defaults":
+			flush()
+			section = "defaults"
+			continue
+		case "frontend":
+			flush()
+			section = "frontend"
+			currentFrontend = &Frontend{Name: fields[1]}
+			continue
+		case "backend":
+			flush()
+			section = "backend"
+			currentBackend = &Backend{Name: fields[1]}
+			continue
+		}
+
+		switch section {
+		case "global":
+			model.Globals = append(model.Globals, line)
+		case "defaults":
+			model.Defaults = append(model.Defaults, line)
+		case "frontend":
+			parseFrontendDirective(currentFrontend, keyword, fields, line)
+		case "backend":
+			parseBackendDirective(currentBackend, keyword, fields, line)
+		}
+	}
+
+	flush()
+
+	return model, nil
+}
+
+func parseFrontendDirective(f *Frontend, keyword string, fields []string, line string) {
+	switch keyword {
+	case "bind":
+		f.Binds = append(f.Binds, strings.Join(fields[1:], " "))
+	case "acl":
+		if len(fields) >= 3 {
+			f.ACLs = append(f.ACLs, ACL{Name: fields[1], Condition: strings.Join(fields[2:], " ")})
+		}
+	case "use_backend":
+		ub := UseBackend{Backend: fields[1]}
+		if idx := fieldIndex(fields, "if"); idx >= 0 {
+			ub.Condition = strings.Join(fields[idx+1:], " ")
+		}
+		f.UseBackends = append(f.UseBackends, ub)
+	default:
+		f.Options = append(f.Options, line)
+	}
+}
+
+func parseBackendDirective(b *Backend, keyword string, fields []string, line string) {
+	if keyword != "server" {
+		b.Options = append(b.Options, line)
+		return
+	}
+
+	server := BackendServer{Name: fields[1], Address: fields[2]}
+	for i := 3; i < len(fields); i++ {
+		switch fields[i] {
+		case "weight":
+			if i+1 < len(fields) {
+				server.Weight, _ = strconv.Atoi(fields[i+1])
+				i++
+			}
+		case "maxconn":
+			if i+1 < len(fields) {
+				server.MaxConn, _ = strconv.Atoi(fields[i+1])
+				i++
+			}
+		default:
+			server.Options = append(server.Options, fields[i])
+		}
+	}
+
+	b.Servers = append(b.Servers, server)
+}
+
+func fieldIndex(fields []string, target string) int {
+	for i, f := range fields {
+		if f == target {
+			return i
+		}
+	}
+	return -1
+}
+
+// Render serializes a HAProxyModel back into canonical HAProxy config text,
+// the compatibility shim that lets operators author configs declaratively
+// instead of through the template.
+func (m *HAProxyModel) Render() string {
+	var sb strings.Builder
+
+	if len(m.Globals) > 0 {
+		sb.WriteString("global\n")
+		for _, l := range m.Globals {
+			sb.WriteString("\t" + l + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(m.Defaults) > 0 {
+		sb.WriteString("defaults\n")
+		for _, l := range m.Defaults {
+			sb.WriteString("\t" + l + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	for _, f := range m.Frontends {
+		sb.WriteString("frontend " + f.Name + "\n")
+		for _, bind := range f.Binds {
+			sb.WriteString("\tbind " + bind + "\n")
+		}
+		for _, acl := range f.ACLs {
+			sb.WriteString("\tacl " + acl.Name + " " + acl.Condition + "\n")
+		}
+		for _, opt := range f.Options {
+			sb.WriteString("\t" + opt + "\n")
+		}
+		for _, ub := range f.UseBackends {
+			line := "\tuse_backend " + ub.Backend
+			if ub.Condition != "" {
+				line += " if " + ub.Condition
+			}
+			sb.WriteString(line + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	for _, b := range m.Backends {
+		sb.WriteString("backend " + b.Name + "\n")
+		for _, opt := range b.Options {
+			sb.WriteString("\t" + opt + "\n")
+		}
+		for _, s := range b.Servers {
+			sb.WriteString("\tserver " + renderServerLine(s) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func renderServerLine(s BackendServer) string {
+	parts := []string{s.Name, s.Address}
+	if s.Weight > 0 {
+		parts = append(parts, "weight", strconv.Itoa(s.Weight))
+	}
+	if s.MaxConn > 0 {
+		parts = append(parts, "maxconn", strconv.Itoa(s.MaxConn))
+	}
+	parts = append(parts, s.Options...)
+
+	return strings.Join(parts, " ")
+}
+
+// ConfigDiff classifies the differences between two HAProxyModels. Server
+// membership/weight/maxconn/address changes within a backend are NOT
+// considered here at all -- UpdateBackends already keeps those converged in
+// place over the runtime socket using LocallyRequiredServices and "show
+// servers state", which Diff has no visibility into, so Diff only ever
+// flags changes UpdateBackends can't apply itself (new/removed frontends or
+// backends, other backend options, bind changes, global/defaults/certs
+// changes), with ReloadReasons explaining why for logging.
+type ConfigDiff struct {
+	ReloadRequired bool
+	ReloadReasons  []string
+}
+
+// Diff compares old and new HAProxyModels and classifies what changed. See
+// ConfigDiff.
+func Diff(old, new *HAProxyModel) ConfigDiff {
+	diff := ConfigDiff{}
+
+	if !stringSlicesEqual(old.Globals, new.Globals) {
+		diff.ReloadRequired = true
+		diff.ReloadReasons = append(diff.ReloadReasons, "global section changed")
+	}
+	if !stringSlicesEqual(old.Defaults, new.Defaults) {
+		diff.ReloadRequired = true
+		diff.ReloadReasons = append(diff.ReloadReasons, "defaults section changed")
+	}
+	if !stringMapsEqual(old.Certs, new.Certs) {
+		diff.ReloadRequired = true
+		diff.ReloadReasons = append(diff.ReloadReasons, "certs changed")
+	}
+
+	oldFrontends := frontendsByName(old.Frontends)
+	newFrontends := frontendsByName(new.Frontends)
+
+	for name, nf := range newFrontends {
+		of, ok := oldFrontends[name]
+		if !ok {
+			diff.ReloadRequired = true
+			diff.ReloadReasons = append(diff.ReloadReasons, "frontend "+name+" added")
+			continue
+		}
+		if !frontendsEqual(of, nf) {
+			diff.ReloadRequired = true
+			diff.ReloadReasons = append(diff.ReloadReasons, "frontend "+name+" changed")
+		}
+	}
+	for name := range oldFrontends {
+		if _, ok := newFrontends[name]; !ok {
+			diff.ReloadRequired = true
+			diff.ReloadReasons = append(diff.ReloadReasons, "frontend "+name+" removed")
+		}
+	}
+
+	oldBackends := backendsByName(old.Backends)
+	newBackends := backendsByName(new.Backends)
+
+	for name, nb := range newBackends {
+		ob, ok := oldBackends[name]
+		if !ok {
+			diff.ReloadRequired = true
+			diff.ReloadReasons = append(diff.ReloadReasons, "backend "+name+" added")
+			continue
+		}
+		if !stringSlicesEqual(ob.Options, nb.Options) {
+			diff.ReloadRequired = true
+			diff.ReloadReasons = append(diff.ReloadReasons, "backend "+name+" options changed")
+		}
+	}
+	for name := range oldBackends {
+		if _, ok := newBackends[name]; !ok {
+			diff.ReloadRequired = true
+			diff.ReloadReasons = append(diff.ReloadReasons, "backend "+name+" removed")
+		}
+	}
+
+	return diff
+}
+
+func frontendsByName(frontends []Frontend) map[string]Frontend {
+	m := make(map[string]Frontend)
+	for _, f := range frontends {
+		m[f.Name] = f
+	}
+	return m
+}
+
+func backendsByName(backends []Backend) map[string]Backend {
+	m := make(map[string]Backend)
+	for _, b := range backends {
+		m[b.Name] = b
+	}
+	return m
+}
+
+func frontendsEqual(a, b Frontend) bool {
+	return stringSlicesEqual(a.Binds, b.Binds) &&
+		stringSlicesEqual(a.Options, b.Options) &&
+		aclsEqual(a.ACLs, b.ACLs) &&
+		useBackendsEqual(a.UseBackends, b.UseBackends)
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func aclsEqual(a, b []ACL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func useBackendsEqual(a, b []UseBackend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// applyModelDiff parses configText into a HAProxyModel, attaches certs (not
+// part of the rendered text, see HAProxyModel.Certs) and, if a model from a
+// previous convergence is cached, diffs the two to decide whether a reload
+// is required. Diff never looks at per-server membership at all: UpdateBackends
+// is the sole owner of runtime add/del/enable/disable commands for backend
+// servers, driven off LocallyRequiredServices and "show servers state", so
+// this is purely the reload/no-reload gate for everything UpdateBackends
+// can't see -- frontends, globals, defaults and certs.
+func (hac *HAProxySettings) applyModelDiff(configText string, certs map[string]string) bool {
+	model, err := ParseHAProxyModel(configText)
+	if err != nil {
+		log.Warning("Could not parse rendered HAProxy config into typed model: " + err.Error())
+		return false
+	}
+	model.Certs = certs
+
+	previous := hac.lastModel
+	hac.lastModel = model
+
+	if previous == nil {
+		return false
+	}
+
+	diff := Diff(previous, model)
+	if diff.ReloadRequired {
+		log.Debug("HAProxy config diff requires reload: " + strings.Join(diff.ReloadReasons, ", "))
+	}
+
+	return diff.ReloadRequired
+}
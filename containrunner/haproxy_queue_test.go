@@ -0,0 +1,110 @@
+package containrunner
+
+import (
+	"testing"
+	"time"
+)
+
+// TestQueueConvergeHAProxyCoalescesBurst drives QueueConvergeHAProxy with a
+// stubbed convergeFunc so the single-writer loop's coalescing can be
+// observed without touching a real HAProxy: while a request is in flight, a
+// burst of further snapshots must collapse down to only the latest one, the
+// one in between must be dropped, and CoalescedEvents must count the drop.
+func TestQueueConvergeHAProxyCoalescesBurst(t *testing.T) {
+	hac := &HAProxySettings{}
+
+	started := make(chan *RuntimeConfiguration, 10)
+	release := make(chan struct{})
+	hac.convergeFunc = func(configuration *RuntimeConfiguration, localInstanceInformation *LocalInstanceInformation) error {
+		started <- configuration
+		<-release
+		return nil
+	}
+
+	info := &LocalInstanceInformation{}
+	a := &RuntimeConfiguration{}
+	b := &RuntimeConfiguration{}
+	c := &RuntimeConfiguration{}
+
+	hac.QueueConvergeHAProxy(a, info)
+	if got := <-started; got != a {
+		t.Fatalf("expected the first queued snapshot to run first")
+	}
+
+	// The loop is now blocked inside convergeFunc processing "a", so "b"
+	// and "c" queue up behind it; "c" must push "b" out rather than both
+	// being processed.
+	hac.QueueConvergeHAProxy(b, info)
+	hac.QueueConvergeHAProxy(c, info)
+
+	release <- struct{}{} // let "a" finish so the loop picks up the next request
+
+	select {
+	case got := <-started:
+		if got != c {
+			t.Fatalf("expected \"c\" to run after \"a\", the stale \"b\" should have been dropped")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the coalesced request to run")
+	}
+	release <- struct{}{}
+
+	select {
+	case got := <-started:
+		t.Fatalf("expected no further request to run, got %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if hac.Metrics.CoalescedEvents != 1 {
+		t.Errorf("expected CoalescedEvents=1, got %d", hac.Metrics.CoalescedEvents)
+	}
+}
+
+// TestWaitForMinReloadIntervalThrottles covers the min-reload-interval
+// throttle: a call right after a recorded reload must block until the
+// configured interval has elapsed.
+func TestWaitForMinReloadIntervalThrottles(t *testing.T) {
+	hac := &HAProxySettings{MinReloadInterval: 50 * time.Millisecond}
+	hac.recordReload()
+
+	start := time.Now()
+	hac.waitForMinReloadInterval()
+	elapsed := time.Since(start)
+
+	if elapsed < hac.MinReloadInterval {
+		t.Errorf("expected to wait at least %v, only waited %v", hac.MinReloadInterval, elapsed)
+	}
+}
+
+// TestWaitForMinReloadIntervalNoThrottleWhenUnset covers the common case of
+// MinReloadInterval being left at its zero value: no throttling at all.
+func TestWaitForMinReloadIntervalNoThrottleWhenUnset(t *testing.T) {
+	hac := &HAProxySettings{}
+	hac.recordReload()
+
+	start := time.Now()
+	hac.waitForMinReloadInterval()
+
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("expected no throttling with MinReloadInterval unset, waited %v", elapsed)
+	}
+}
+
+// TestRecordReload covers the Metrics bookkeeping recordReload is
+// responsible for.
+func TestRecordReload(t *testing.T) {
+	hac := &HAProxySettings{}
+
+	hac.recordReload()
+	if hac.Metrics.Reloads != 1 {
+		t.Errorf("expected Reloads=1, got %d", hac.Metrics.Reloads)
+	}
+	if hac.Metrics.LastReloadTime.IsZero() {
+		t.Errorf("expected LastReloadTime to be set")
+	}
+
+	hac.recordReload()
+	if hac.Metrics.Reloads != 2 {
+		t.Errorf("expected Reloads=2 after a second reload, got %d", hac.Metrics.Reloads)
+	}
+}
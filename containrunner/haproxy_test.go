@@ -0,0 +1,279 @@
+package containrunner
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPlanBackendSyncGrow covers adding a new endpoint to an existing
+// backend section: "add server" must be followed by "enable server" in the
+// same batch, since a freshly added slot starts out in MAINT and nothing
+// else would ever turn it on.
+func TestPlanBackendSyncGrow(t *testing.T) {
+	current_backends := map[string]map[string]string{
+		"myservice": {"myservice-10.0.0.1:80": "UP"},
+	}
+	desired := map[string][]string{
+		"myservice": {"10.0.0.1:80", "10.0.0.2:80"},
+	}
+
+	commands, reload_required := planBackendSync(current_backends, nil, desired, true)
+	if reload_required {
+		t.Fatalf("expected no reload required, got reload_required=true")
+	}
+
+	wantAdd := "add server myservice/myservice-10.0.0.2:80 10.0.0.2:80\n"
+	wantEnable := "enable server myservice/myservice-10.0.0.2:80\n"
+	if !strings.Contains(commands, wantAdd) {
+		t.Errorf("commands missing %q, got: %q", wantAdd, commands)
+	}
+	if !strings.Contains(commands, wantEnable) {
+		t.Errorf("commands missing %q, got: %q", wantEnable, commands)
+	}
+	if strings.Index(commands, wantAdd)+len(wantAdd) != strings.Index(commands, wantEnable) {
+		t.Errorf("expected \"enable server\" to immediately follow \"add server\" in the same batch, got: %q", commands)
+	}
+}
+
+// TestPlanBackendSyncShrink covers removing an endpoint that's no longer
+// desired: it must be disabled and deleted via the runtime API.
+func TestPlanBackendSyncShrink(t *testing.T) {
+	current_backends := map[string]map[string]string{
+		"myservice": {
+			"myservice-10.0.0.1:80": "UP",
+			"myservice-10.0.0.2:80": "UP",
+		},
+	}
+	desired := map[string][]string{
+		"myservice": {"10.0.0.1:80"},
+	}
+
+	commands, reload_required := planBackendSync(current_backends, nil, desired, true)
+	if reload_required {
+		t.Fatalf("expected no reload required, got reload_required=true")
+	}
+
+	wantDisable := "disable server myservice/myservice-10.0.0.2:80\n"
+	wantDel := "del server myservice/myservice-10.0.0.2:80\n"
+	if !strings.Contains(commands, wantDisable) {
+		t.Errorf("commands missing %q, got: %q", wantDisable, commands)
+	}
+	if !strings.Contains(commands, wantDel) {
+		t.Errorf("commands missing %q, got: %q", wantDel, commands)
+	}
+	if strings.Contains(commands, "myservice-10.0.0.1:80") {
+		t.Errorf("unaffected endpoint should not appear in commands, got: %q", commands)
+	}
+}
+
+// TestPlanBackendSyncMixed covers a grow and a shrink landing in the same
+// convergence: the add/enable pair and the disable/del pair must both be
+// present, and a kept endpoint whose slot already points at the desired
+// address must not produce a "set server ... addr" command.
+func TestPlanBackendSyncMixed(t *testing.T) {
+	current_backends := map[string]map[string]string{
+		"myservice": {
+			"myservice-10.0.0.1:80": "UP",
+			"myservice-10.0.0.2:80": "UP",
+			"myservice-10.0.0.3:80": "UP",
+		},
+	}
+	server_slots := map[string]map[string]HAProxyServerSlot{
+		"myservice": {
+			"myservice-10.0.0.1:80": {BackendName: "myservice", ServerName: "myservice-10.0.0.1:80", Address: "10.0.0.1", Port: "80"},
+		},
+	}
+	desired := map[string][]string{
+		// 10.0.0.1:80 kept, 10.0.0.2:80 dropped, 10.0.0.4:80 added.
+		"myservice": {"10.0.0.1:80", "10.0.0.4:80"},
+	}
+
+	commands, reload_required := planBackendSync(current_backends, server_slots, desired, true)
+	if reload_required {
+		t.Fatalf("expected no reload required, got reload_required=true")
+	}
+
+	for _, want := range []string{
+		"add server myservice/myservice-10.0.0.4:80 10.0.0.4:80\n",
+		"enable server myservice/myservice-10.0.0.4:80\n",
+		"disable server myservice/myservice-10.0.0.2:80\n",
+		"del server myservice/myservice-10.0.0.2:80\n",
+	} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("commands missing %q, got: %q", want, commands)
+		}
+	}
+	if strings.Contains(commands, "10.0.0.1:80 addr") || strings.Contains(commands, "set server") {
+		t.Errorf("unchanged endpoint should not produce a \"set server\" command, got: %q", commands)
+	}
+}
+
+// TestPlanBackendSyncMissingSectionRequiresReload covers the case "add
+// server" can't handle at all: a whole backend section that doesn't exist
+// yet in the running config.
+func TestPlanBackendSyncMissingSectionRequiresReload(t *testing.T) {
+	current_backends := map[string]map[string]string{}
+	desired := map[string][]string{
+		"myservice": {"10.0.0.1:80"},
+	}
+
+	_, reload_required := planBackendSync(current_backends, nil, desired, true)
+	if !reload_required {
+		t.Fatalf("expected reload_required=true for a missing backend section")
+	}
+}
+
+// mockHAProxySocket is a minimal unix socket stand-in for the HAProxy stats
+// socket: it answers "show info"/"show stat"/"show servers state" with
+// canned responses and records every command it receives, so the
+// socket-facing helpers (detectCapabilities, GetHaproxyBackends,
+// GetHaproxyServersState, runHAProxyCommand) can be exercised end to end.
+type mockHAProxySocket struct {
+	showInfoResponse         string
+	showStatResponse         string
+	showServersStateResponse string
+
+	mu       chan struct{}
+	commands []string
+}
+
+func newMockHAProxySocket(t *testing.T) (*mockHAProxySocket, string) {
+	dir, err := ioutil.TempDir("", "haproxy-mock-socket")
+	if err != nil {
+		t.Fatalf("could not create tempdir: %+v", err)
+	}
+
+	socketPath := dir + "/haproxy.sock"
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("could not listen on mock socket: %+v", err)
+	}
+
+	m := &mockHAProxySocket{mu: make(chan struct{}, 1)}
+	m.mu <- struct{}{}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go m.handle(conn)
+		}
+	}()
+
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	return m, socketPath
+}
+
+func (m *mockHAProxySocket) handle(conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, 65536)
+	n, _ := conn.Read(buf)
+	command := string(buf[:n])
+
+	<-m.mu
+	m.commands = append(m.commands, command)
+	m.mu <- struct{}{}
+
+	switch {
+	case strings.HasPrefix(command, "show info"):
+		conn.Write([]byte(m.showInfoResponse))
+	case strings.HasPrefix(command, "show stat"):
+		conn.Write([]byte(m.showStatResponse))
+	case strings.HasPrefix(command, "show servers state"):
+		conn.Write([]byte(m.showServersStateResponse))
+	}
+}
+
+func (m *mockHAProxySocket) recordedCommands() []string {
+	<-m.mu
+	defer func() { m.mu <- struct{}{} }()
+	return append([]string(nil), m.commands...)
+}
+
+// TestUpdateBackendsPlanAgainstMockSocket exercises the socket-facing
+// building blocks behind UpdateBackends (detectCapabilities,
+// GetHaproxyBackends, GetHaproxyServersState, runHAProxyCommand) against a
+// mock unix socket standing in for HAProxy, feeds their output through
+// planBackendSync, and asserts that a newly required endpoint is both
+// added and enabled over the real socket protocol.
+func TestUpdateBackendsPlanAgainstMockSocket(t *testing.T) {
+	mock, socketPath := newMockHAProxySocket(t)
+	mock.showInfoResponse = "Name: HAProxy\nVersion: 2.4.22\n"
+	// "show stat" is a CSV with pxname/svname in columns 0/1 and status in
+	// column 17; pad the columns in between so parts[17] lands on "UP".
+	mock.showStatResponse = "# pxname,svname,\n" +
+		"myservice,myservice-10.0.0.1:80" + strings.Repeat(",", 16) + "UP\n"
+	// "show servers state" is space separated with be_name/srv_name/srv_addr
+	// in columns 1/3/4 and srv_port in column 18 (0-indexed, per HAProxy's
+	// management.txt); pad the rest with placeholder values.
+	serverStateFields := make([]string, 19)
+	for i := range serverStateFields {
+		serverStateFields[i] = "0"
+	}
+	serverStateFields[1] = "myservice"
+	serverStateFields[3] = "myservice-10.0.0.1:80"
+	serverStateFields[4] = "10.0.0.1"
+	serverStateFields[18] = "80"
+	mock.showServersStateResponse = "#1\n# be_id be_name srv_id srv_name srv_addr ...\n" + strings.Join(serverStateFields, " ") + "\n"
+
+	hac := &HAProxySettings{HAProxySocket: socketPath}
+
+	current_backends, err := hac.GetHaproxyBackends()
+	if err != nil {
+		t.Fatalf("GetHaproxyBackends returned error: %+v", err)
+	}
+
+	hac.detectCapabilities()
+	if !hac.Capabilities.SupportsAddDelServer {
+		t.Fatalf("expected capability detection to recognize haproxy 2.4 as supporting add/del server")
+	}
+
+	server_slots, err := hac.GetHaproxyServersState()
+	if err != nil {
+		t.Fatalf("GetHaproxyServersState returned error: %+v", err)
+	}
+
+	desired := map[string][]string{
+		"myservice": {"10.0.0.1:80", "10.0.0.2:80"},
+	}
+
+	commands, reload_required := planBackendSync(current_backends, server_slots, desired, hac.Capabilities.SupportsAddDelServer)
+	if reload_required {
+		t.Fatalf("expected reload_required=false, got true")
+	}
+
+	if err := runHAProxyCommand(commands, socketPath); err != nil {
+		t.Fatalf("runHAProxyCommand returned error: %+v", err)
+	}
+
+	// runHAProxyCommand returns as soon as its write completes; the mock's
+	// Accept/handle goroutines record the command asynchronously, so give
+	// them a bounded amount of time to catch up instead of racing them.
+	var runtimeCommand string
+	deadline := time.Now().Add(time.Second)
+	for runtimeCommand == "" && time.Now().Before(deadline) {
+		for _, command := range mock.recordedCommands() {
+			if strings.Contains(command, "add server") {
+				runtimeCommand = command
+			}
+		}
+		if runtimeCommand == "" {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	wantAdd := "add server myservice/myservice-10.0.0.2:80 10.0.0.2:80\n"
+	wantEnable := "enable server myservice/myservice-10.0.0.2:80\n"
+	if !strings.Contains(runtimeCommand, wantAdd) || !strings.Contains(runtimeCommand, wantEnable) {
+		t.Fatalf("expected add+enable for the new endpoint on the socket, got: %q", runtimeCommand)
+	}
+}